@@ -0,0 +1,118 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// storeEnvVar lets users force a particular native credential store
+// without editing the config file, e.g. DOCKER_CREDENTIAL_GCR_STORE=file
+// to opt back into the plaintext JSON store.
+const storeEnvVar = "DOCKER_CREDENTIAL_GCR_STORE"
+
+// credentialHelperConfigFilename names the optional config file, alongside
+// the gcloud config, consulted to select a non-default store.
+const credentialHelperConfigFilename = "docker_credential_helper.conf"
+
+// backendGCRServerURL is the well-known key the GCR OAuth tokens are
+// stored under in a native helper, since they aren't tied to a registry
+// the way 3rd party credentials.Credentials are.
+const backendGCRServerURL = "docker-credential-gcr.oauth-tokens"
+
+// backendGCRCredSourceURL is the well-known key the configured
+// credentialSourceConfig is stored under in a native helper.
+const backendGCRCredSourceURL = "docker-credential-gcr.credential-source"
+
+// defaultPlatformHelper and platformHelper are implemented per-OS in
+// backend_darwin.go, backend_linux.go, backend_windows.go and
+// backend_other.go.
+//
+// defaultPlatformHelper returns the credential helper this platform uses
+// when the user hasn't made an explicit choice, or ok == false if none is
+// available (e.g. an unsupported OS).
+//
+// platformHelper resolves an explicitly-named store, or ok == false if
+// name isn't recognized or available on this platform.
+
+// selectedHelper resolves which native credential helper (if any) should
+// back the store, honoring DOCKER_CREDENTIAL_GCR_STORE and
+// docker_credential_helper.conf (in that order) ahead of the per-platform
+// default. A nil helper with ok == false means the legacy file store
+// should be used.
+func selectedHelper() (name string, helper credentials.Helper, ok bool, err error) {
+	name = os.Getenv(storeEnvVar)
+	if name == "" {
+		name, _ = configuredStoreName()
+	}
+
+	if name == "" {
+		return defaultHelperIfAvailable()
+	}
+	if name == "file" {
+		return "", nil, false, nil
+	}
+
+	helper, ok = platformHelper(name)
+	if !ok {
+		return "", nil, false, authErr("credential store "+strconv.Quote(name)+" is unknown or unavailable on this platform", nil)
+	}
+	return name, helper, true, nil
+}
+
+// defaultHelperIfAvailable resolves the per-platform default native
+// helper and probes it before committing to it, since a helper merely
+// being compiled in says nothing about whether it's actually reachable:
+// the Linux secretservice backend, for instance, needs a running D-Bus
+// session and secret-service daemon (gnome-keyring, kwallet, ...), which
+// headless servers, CI runners, and GCE/GKE nodes typically don't have.
+// Falls back to the legacy file store, the same as an unsupported
+// platform would, if the probe fails.
+func defaultHelperIfAvailable() (string, credentials.Helper, bool, error) {
+	name, helper, ok, err := defaultPlatformHelper()
+	if err != nil || !ok {
+		return "", nil, false, err
+	}
+	if !probeHelper(helper) {
+		return "", nil, false, nil
+	}
+	return name, helper, true, nil
+}
+
+// probeHelper reports whether helper is actually usable right now, via a
+// cheap List() call.
+func probeHelper(helper credentials.Helper) bool {
+	_, err := helper.List()
+	return err == nil
+}
+
+// configuredStoreName reads the store name out of
+// ~/.config/gcloud/docker_credential_helper.conf, if present.
+func configuredStoreName() (string, error) {
+	configPath, err := sdkConfigPath()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(filepath.Join(configPath, credentialHelperConfigFilename))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin
+// +build darwin
+
+package store
+
+import (
+	"bytes"
+	"os/exec"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// keychainService is the macOS Keychain "service" attribute every entry
+// we create is tagged with, so List/Get/Delete only ever see our own
+// entries.
+const keychainService = "docker-credential-gcr"
+
+// keychainHelper implements credentials.Helper atop the macOS Keychain by
+// shelling out to /usr/bin/security, which ships with every macOS
+// install, rather than cgo-binding the Security framework directly.
+type keychainHelper struct{}
+
+func defaultPlatformHelper() (string, credentials.Helper, bool, error) {
+	return "keychain", keychainHelper{}, true, nil
+}
+
+func platformHelper(name string) (credentials.Helper, bool) {
+	if name == "keychain" {
+		return keychainHelper{}, true
+	}
+	return nil, false
+}
+
+func (keychainHelper) Add(creds *credentials.Credentials) error {
+	// Clear any existing entry first; -U below also updates an entry in
+	// place should one still be present.
+	exec.Command("/usr/bin/security", "delete-generic-password",
+		"-s", keychainService, "-a", creds.ServerURL).Run()
+
+	return exec.Command("/usr/bin/security", "add-generic-password",
+		"-s", keychainService, "-a", creds.ServerURL, "-w", creds.Secret, "-U").Run()
+}
+
+func (keychainHelper) Delete(serverURL string) error {
+	err := exec.Command("/usr/bin/security", "delete-generic-password",
+		"-s", keychainService, "-a", serverURL).Run()
+	if isKeychainItemNotFound(err) {
+		return credentials.NewErrCredentialsNotFound()
+	}
+	return err
+}
+
+func (keychainHelper) Get(serverURL string) (string, string, error) {
+	out, err := exec.Command("/usr/bin/security", "find-generic-password",
+		"-s", keychainService, "-a", serverURL, "-w").Output()
+	if isKeychainItemNotFound(err) {
+		return "", "", credentials.NewErrCredentialsNotFound()
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return serverURL, string(bytes.TrimSpace(out)), nil
+}
+
+func (keychainHelper) List() (map[string]string, error) {
+	out, err := exec.Command("/usr/bin/security", "dump-keychain").Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseKeychainAccounts(out), nil
+}
+
+// isKeychainItemNotFound reports whether err is the exit status security
+// returns for "The specified item could not be found in the keychain."
+func isKeychainItemNotFound(err error) bool {
+	exitErr, ok := err.(*exec.ExitError)
+	return ok && exitErr.ExitCode() == 44
+}
+
+// parseKeychainAccounts scrapes the "acct" attribute of every entry in
+// `security dump-keychain` output that's tagged with our service name.
+func parseKeychainAccounts(dump []byte) map[string]string {
+	accounts := map[string]string{}
+	for _, entry := range bytes.Split(dump, []byte("keychain: ")) {
+		if !bytes.Contains(entry, []byte(`"svce"<blob>="`+keychainService+`"`)) {
+			continue
+		}
+		if acct := keychainAttr(entry, "acct"); acct != "" {
+			accounts[acct] = acct
+		}
+	}
+	return accounts
+}
+
+func keychainAttr(entry []byte, attr string) string {
+	marker := []byte(`"` + attr + `"<blob>="`)
+	idx := bytes.Index(entry, marker)
+	if idx < 0 {
+		return ""
+	}
+	rest := entry[idx+len(marker):]
+	end := bytes.IndexByte(rest, '"')
+	if end < 0 {
+		return ""
+	}
+	return string(rest[:end])
+}
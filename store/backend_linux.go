@@ -0,0 +1,127 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package store
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// secretServiceAttr is the fixed "service" attribute every entry we
+// create is tagged with, so search/list only ever sees our own entries.
+const secretServiceAttr = "docker-credential-gcr"
+
+// secretServiceHelper implements credentials.Helper atop the Linux
+// Secret Service by shelling out to secret-tool, part of the libsecret
+// utilities commonly packaged alongside gnome-keyring/kwallet, rather
+// than cgo-binding libsecret directly: that required a C compiler and
+// the libsecret-1 development headers just to build this tool, even on
+// hosts that never use the Secret Service backend.
+type secretServiceHelper struct{}
+
+func defaultPlatformHelper() (string, credentials.Helper, bool, error) {
+	return "secretservice", secretServiceHelper{}, true, nil
+}
+
+func platformHelper(name string) (credentials.Helper, bool) {
+	if name == "secretservice" {
+		return secretServiceHelper{}, true
+	}
+	return nil, false
+}
+
+func secretServiceAttrs(serverURL string) []string {
+	return []string{"service", secretServiceAttr, "server", serverURL}
+}
+
+func (secretServiceHelper) Add(creds *credentials.Credentials) error {
+	args := append([]string{"store", "--label=" + creds.ServerURL}, secretServiceAttrs(creds.ServerURL)...)
+	args = append(args, "account", creds.Username)
+
+	cmd := exec.Command("secret-tool", args...)
+	cmd.Stdin = strings.NewReader(creds.Secret)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return authErr("secret-tool store failed: "+string(bytes.TrimSpace(out)), err)
+	}
+	return nil
+}
+
+func (secretServiceHelper) Delete(serverURL string) error {
+	args := append([]string{"clear"}, secretServiceAttrs(serverURL)...)
+	if err := exec.Command("secret-tool", args...).Run(); err != nil {
+		return credentials.NewErrCredentialsNotFound()
+	}
+	return nil
+}
+
+func (secretServiceHelper) Get(serverURL string) (string, string, error) {
+	args := append([]string{"lookup"}, secretServiceAttrs(serverURL)...)
+	secret, err := exec.Command("secret-tool", args...).Output()
+	if err != nil || len(secret) == 0 {
+		return "", "", credentials.NewErrCredentialsNotFound()
+	}
+
+	username, err := secretServiceAccount(serverURL)
+	if err != nil {
+		return "", "", err
+	}
+	return username, string(secret), nil
+}
+
+func (secretServiceHelper) List() (map[string]string, error) {
+	out, err := exec.Command("secret-tool", "search", "--all", "service", secretServiceAttr).Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseSecretServiceEntries(out), nil
+}
+
+// secretServiceAccount looks up just the "account" attribute secret-tool
+// search reports for the entry tagged with serverURL, since secret-tool
+// lookup only ever prints the secret itself.
+func secretServiceAccount(serverURL string) (string, error) {
+	out, err := exec.Command("secret-tool", "search", "--all", "service", secretServiceAttr, "server", serverURL).Output()
+	if err != nil {
+		return "", err
+	}
+	entries := parseSecretServiceEntries(out)
+	return entries[serverURL], nil
+}
+
+// parseSecretServiceEntries scrapes the "attribute.server" and
+// "attribute.account" lines out of `secret-tool search` output, which
+// prints one block of "key = value" lines per matching item.
+func parseSecretServiceEntries(search []byte) map[string]string {
+	entries := map[string]string{}
+	var serverURL string
+	for _, line := range strings.Split(string(search), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "attribute.server = "):
+			serverURL = strings.TrimPrefix(line, "attribute.server = ")
+		case strings.HasPrefix(line, "attribute.account = "):
+			if serverURL != "" {
+				entries[serverURL] = strings.TrimPrefix(line, "attribute.account = ")
+			}
+		}
+	}
+	return entries
+}
@@ -0,0 +1,30 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !darwin && !linux && !windows
+// +build !darwin,!linux,!windows
+
+package store
+
+import "github.com/docker/docker-credential-helpers/credentials"
+
+// No native credential helper is known for this platform; callers fall
+// back to the plaintext file store.
+func defaultPlatformHelper() (string, credentials.Helper, bool, error) {
+	return "", nil, false, nil
+}
+
+func platformHelper(name string) (credentials.Helper, bool) {
+	return nil, false
+}
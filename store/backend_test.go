@@ -0,0 +1,65 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// fakeHelper is a minimal credentials.Helper whose List() error is
+// controllable, for exercising probeHelper without depending on any real
+// OS credential store being reachable.
+type fakeHelper struct {
+	listErr error
+}
+
+func (fakeHelper) Add(*credentials.Credentials) error { return nil }
+func (fakeHelper) Delete(string) error                { return nil }
+func (fakeHelper) Get(string) (string, string, error) { return "", "", nil }
+func (f fakeHelper) List() (map[string]string, error) {
+	if f.listErr != nil {
+		return nil, f.listErr
+	}
+	return map[string]string{}, nil
+}
+
+func TestProbeHelper(t *testing.T) {
+	if !probeHelper(fakeHelper{}) {
+		t.Error("probeHelper(healthy) = false, want true")
+	}
+	if probeHelper(fakeHelper{listErr: errors.New("no D-Bus session")}) {
+		t.Error("probeHelper(failing) = true, want false")
+	}
+}
+
+func TestSelectedHelperEnvVarFile(t *testing.T) {
+	t.Setenv(storeEnvVar, "file")
+
+	name, helper, ok, err := selectedHelper()
+	if err != nil || ok || helper != nil || name != "" {
+		t.Errorf("selectedHelper() = (%q, %v, %v, %v), want (\"\", nil, false, nil)", name, helper, ok, err)
+	}
+}
+
+func TestSelectedHelperEnvVarUnknown(t *testing.T) {
+	t.Setenv(storeEnvVar, "not-a-real-store")
+
+	if _, _, ok, err := selectedHelper(); ok || err == nil {
+		t.Errorf("selectedHelper() ok=%v err=%v, want ok=false and a non-nil error", ok, err)
+	}
+}
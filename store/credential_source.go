@@ -0,0 +1,176 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/docker-credential-gcr/config"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// The supported values for credentialSourceConfig.Kind. All but
+// CredentialSourceServiceAccount, which is set via SetGCRServiceAccount,
+// are also accepted as the kind argument to SetGCRCredentialSource.
+const (
+	CredentialSourceUser           = "user"
+	CredentialSourceServiceAccount = "serviceaccount"
+	CredentialSourceGCE            = "gce"
+	CredentialSourceADC            = "adc"
+)
+
+// credentialSourceConfig is the persisted record of which CredentialSource
+// GetGCRAuth should construct, alongside the GCR tokens themselves.
+type credentialSourceConfig struct {
+	Kind               string `json:"kind"`
+	ServiceAccountPath string `json:"serviceAccountPath,omitempty"`
+}
+
+// CredentialSource abstracts over where GCR's OAuth tokens ultimately
+// come from: a stored interactive user login, a service account key, the
+// GCE/GKE metadata server, or Application Default Credentials. It lets
+// GetGCRAuth return a uniform *GCRAuth regardless of which one is in use.
+type CredentialSource interface {
+	// TokenSource returns an oauth2.TokenSource producing GCR access
+	// tokens, refreshing them as needed using ctx.
+	TokenSource(ctx context.Context) oauth2.TokenSource
+}
+
+// credentialSourceFromConfig builds the CredentialSource named by cfg,
+// falling back to the stored user OAuth tokens in t when cfg is absent,
+// for compatibility with credential files written before this existed.
+func credentialSourceFromConfig(cfg *credentialSourceConfig, t *tokens) (CredentialSource, error) {
+	kind := CredentialSourceUser
+	if cfg != nil && cfg.Kind != "" {
+		kind = cfg.Kind
+	}
+
+	switch kind {
+	case CredentialSourceUser:
+		if t == nil {
+			return nil, errors.New("GCR Credentials not present in store")
+		}
+		return userOAuthSourceFromTokens(t), nil
+	case CredentialSourceServiceAccount:
+		return serviceAccountSource{keyPath: cfg.ServiceAccountPath}, nil
+	case CredentialSourceGCE:
+		return gceMetadataSource{}, nil
+	case CredentialSourceADC:
+		return adcSource{}, nil
+	default:
+		return nil, authErr("unknown GCR credential source "+strconv.Quote(kind), nil)
+	}
+}
+
+// userOAuthSource produces tokens from a previously stored interactive
+// user login (see SetGCRAuth), refreshing the access token through the
+// standard GCR OAuth2 client as needed.
+type userOAuthSource struct {
+	conf         *oauth2.Config
+	initialToken *oauth2.Token
+}
+
+func userOAuthSourceFromTokens(t *tokens) userOAuthSource {
+	var expiry time.Time
+	if t.TokenExpiry != nil {
+		expiry = *t.TokenExpiry
+	}
+
+	return userOAuthSource{
+		conf: &oauth2.Config{
+			ClientID:     config.GCRCredHelperClientID,
+			ClientSecret: config.GCRCredHelperClientNotSoSecret,
+			Scopes:       config.GCRScopes,
+			Endpoint:     google.Endpoint,
+			RedirectURL:  "oob",
+		},
+		initialToken: &oauth2.Token{
+			AccessToken:  t.AccessToken,
+			RefreshToken: t.RefreshToken,
+			Expiry:       expiry,
+		},
+	}
+}
+
+func (s userOAuthSource) TokenSource(ctx context.Context) oauth2.TokenSource {
+	return s.conf.TokenSource(ctx, s.initialToken)
+}
+
+// serviceAccountSource produces tokens from a service account key file,
+// for use on CI runners and other environments without an interactive
+// gcloud login.
+type serviceAccountSource struct {
+	keyPath string
+}
+
+func (s serviceAccountSource) TokenSource(ctx context.Context) oauth2.TokenSource {
+	return &lazyTokenSource{build: func() (oauth2.TokenSource, error) {
+		data, err := os.ReadFile(s.keyPath)
+		if err != nil {
+			return nil, authErr("failed to read service account key "+s.keyPath, err)
+		}
+		jwtConf, err := google.JWTConfigFromJSON(data, config.GCRScopes...)
+		if err != nil {
+			return nil, authErr("not a valid service account key: "+s.keyPath, err)
+		}
+		return jwtConf.TokenSource(ctx), nil
+	}}
+}
+
+// gceMetadataSource produces tokens from the GCE/GKE metadata server,
+// using the instance's (or node's) attached service account.
+type gceMetadataSource struct{}
+
+func (gceMetadataSource) TokenSource(ctx context.Context) oauth2.TokenSource {
+	return google.ComputeTokenSource("")
+}
+
+// adcSource produces tokens via Application Default Credentials, letting
+// the helper work uniformly wherever ADC is already configured.
+type adcSource struct{}
+
+func (adcSource) TokenSource(ctx context.Context) oauth2.TokenSource {
+	return &lazyTokenSource{build: func() (oauth2.TokenSource, error) {
+		creds, err := google.FindDefaultCredentials(ctx, config.GCRScopes...)
+		if err != nil {
+			return nil, authErr("failed to find Application Default Credentials", err)
+		}
+		return creds.TokenSource, nil
+	}}
+}
+
+// lazyTokenSource defers constructing the real oauth2.TokenSource (and
+// any error doing so) until the first call to Token, since
+// CredentialSource.TokenSource itself cannot fail.
+type lazyTokenSource struct {
+	build func() (oauth2.TokenSource, error)
+	inner oauth2.TokenSource
+	err   error
+}
+
+func (l *lazyTokenSource) Token() (*oauth2.Token, error) {
+	if l.inner == nil && l.err == nil {
+		l.inner, l.err = l.build()
+	}
+	if l.err != nil {
+		return nil, l.err
+	}
+	return l.inner.Token()
+}
@@ -0,0 +1,91 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestCredentialSourceFromConfigDefaultsToUser(t *testing.T) {
+	src, err := credentialSourceFromConfig(nil, &tokens{AccessToken: "at", RefreshToken: "rt"})
+	if err != nil {
+		t.Fatalf("credentialSourceFromConfig(nil, tok): %v", err)
+	}
+	if _, ok := src.(userOAuthSource); !ok {
+		t.Errorf("got %T, want userOAuthSource", src)
+	}
+}
+
+func TestCredentialSourceFromConfigUserWithoutTokens(t *testing.T) {
+	cfg := &credentialSourceConfig{Kind: CredentialSourceUser}
+	if _, err := credentialSourceFromConfig(cfg, nil); err == nil {
+		t.Error("credentialSourceFromConfig(user, nil tokens) = nil error, want non-nil")
+	}
+}
+
+func TestCredentialSourceFromConfigServiceAccount(t *testing.T) {
+	cfg := &credentialSourceConfig{Kind: CredentialSourceServiceAccount, ServiceAccountPath: "/path/to/key.json"}
+	src, err := credentialSourceFromConfig(cfg, nil)
+	if err != nil {
+		t.Fatalf("credentialSourceFromConfig: %v", err)
+	}
+	sa, ok := src.(serviceAccountSource)
+	if !ok {
+		t.Fatalf("got %T, want serviceAccountSource", src)
+	}
+	if sa.keyPath != cfg.ServiceAccountPath {
+		t.Errorf("keyPath = %q, want %q", sa.keyPath, cfg.ServiceAccountPath)
+	}
+}
+
+func TestCredentialSourceFromConfigGCEAndADC(t *testing.T) {
+	src, err := credentialSourceFromConfig(&credentialSourceConfig{Kind: CredentialSourceGCE}, nil)
+	if err != nil {
+		t.Fatalf("gce: %v", err)
+	}
+	if _, ok := src.(gceMetadataSource); !ok {
+		t.Errorf("got %T, want gceMetadataSource", src)
+	}
+
+	src, err = credentialSourceFromConfig(&credentialSourceConfig{Kind: CredentialSourceADC}, nil)
+	if err != nil {
+		t.Fatalf("adc: %v", err)
+	}
+	if _, ok := src.(adcSource); !ok {
+		t.Errorf("got %T, want adcSource", src)
+	}
+}
+
+func TestCredentialSourceFromConfigUnknownKind(t *testing.T) {
+	if _, err := credentialSourceFromConfig(&credentialSourceConfig{Kind: "bogus"}, nil); err == nil {
+		t.Error("credentialSourceFromConfig(bogus) = nil error, want non-nil")
+	}
+}
+
+func TestLazyTokenSourceDefersBuildError(t *testing.T) {
+	wantErr := errors.New("boom")
+	l := &lazyTokenSource{build: func() (oauth2.TokenSource, error) { return nil, wantErr }}
+
+	if _, err := l.Token(); err != wantErr {
+		t.Errorf("Token() err = %v, want %v", err, wantErr)
+	}
+	// The error is cached, not rebuilt, on a second call.
+	if _, err := l.Token(); err != wantErr {
+		t.Errorf("second Token() err = %v, want %v", err, wantErr)
+	}
+}
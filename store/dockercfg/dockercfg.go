@@ -0,0 +1,201 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package dockercfg resolves registry credentials from the Docker CLI's own
+~/.docker/config.json, including any credsStore or credHelpers it names,
+so docker-credential-gcr can act as a unified credential helper for users
+who've already configured other registries (ECR, ACR, Quay, ...) via their
+own helpers.
+*/
+package dockercfg
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// ownHelperName is the suffix after "docker-credential-" this binary
+// itself registers under, as named by the credHelpers/credsStore entry
+// `gcloud auth configure-docker` writes by default ("gcr.io": "gcr").
+// Resolving through a helper of this name would shell back out to this
+// same binary, re-entering this very fallback and recursing forever, so
+// All() treats it as unconfigured instead.
+const ownHelperName = "gcr"
+
+// configFile mirrors the subset of ~/.docker/config.json we care about.
+// See https://docs.docker.com/engine/reference/commandline/cli/#configuration-files.
+type configFile struct {
+	Auths       map[string]authConfig `json:"auths"`
+	CredsStore  string                `json:"credsStore"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+}
+
+type authConfig struct {
+	Auth string `json:"auth"`
+}
+
+// Resolver resolves the credentials the Docker CLI itself would use for a
+// registry, reading ~/.docker/config.json and shelling out to any
+// credsStore/credHelpers it names using the standard
+// docker-credential-helpers protocol.
+type Resolver struct {
+	configPath string
+	cache      map[string]credentials.Credentials
+}
+
+// NewResolver returns a Resolver reading from the current user's
+// ~/.docker/config.json.
+func NewResolver() (*Resolver, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{configPath: filepath.Join(home, ".docker", "config.json")}, nil
+}
+
+// Get resolves the credentials the Docker CLI would use for serverURL.
+// It returns an error satisfying os.IsNotExist if ~/.docker/config.json
+// doesn't exist or has nothing configured for serverURL.
+func (r *Resolver) Get(serverURL string) (*credentials.Credentials, error) {
+	all, err := r.All()
+	if err != nil {
+		return nil, err
+	}
+	creds, ok := all[serverURL]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &creds, nil
+}
+
+// All resolves every credential named by ~/.docker/config.json: literal
+// "auths" entries plus anything held by a configured credsStore or
+// per-registry credHelpers. Results are cached for the life of r.
+func (r *Resolver) All() (map[string]credentials.Credentials, error) {
+	if r.cache != nil {
+		return r.cache, nil
+	}
+
+	cfg, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	all := map[string]credentials.Credentials{}
+	for serverURL, auth := range cfg.Auths {
+		if creds, err := decodeAuth(serverURL, auth.Auth); err == nil {
+			all[serverURL] = *creds
+		}
+	}
+
+	for serverURL, helper := range cfg.CredHelpers {
+		if helper == ownHelperName {
+			continue
+		}
+		if creds, err := execHelperGet(helper, serverURL); err == nil {
+			all[serverURL] = *creds
+		}
+	}
+
+	if cfg.CredsStore != "" && cfg.CredsStore != ownHelperName {
+		names, err := execHelperList(cfg.CredsStore)
+		if err == nil {
+			for serverURL := range names {
+				if _, exists := all[serverURL]; exists {
+					continue
+				}
+				if creds, err := execHelperGet(cfg.CredsStore, serverURL); err == nil {
+					all[serverURL] = *creds
+				}
+			}
+		}
+	}
+
+	r.cache = all
+	return all, nil
+}
+
+func (r *Resolver) load() (*configFile, error) {
+	data, err := os.ReadFile(r.configPath)
+	if err != nil {
+		return nil, err
+	}
+	var cfg configFile
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// decodeAuth decodes a base64 "user:pass" auth entry, as Docker itself
+// stores plaintext-auth'd registries in its config.json.
+func decodeAuth(serverURL, auth string) (*credentials.Credentials, error) {
+	decoded, err := base64.StdEncoding.DecodeString(auth)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("dockercfg: malformed auth entry for " + serverURL)
+	}
+	return &credentials.Credentials{ServerURL: serverURL, Username: parts[0], Secret: parts[1]}, nil
+}
+
+// helperResponse is the JSON a `docker-credential-<name> get` invocation
+// writes to stdout, per the protocol docker-credential-helpers defines.
+type helperResponse struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// execHelperGet runs `docker-credential-<name> get`, writing serverURL to
+// its stdin as the protocol requires.
+func execHelperGet(name, serverURL string) (*credentials.Credentials, error) {
+	cmd := exec.Command("docker-credential-"+name, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var resp helperResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, err
+	}
+	return &credentials.Credentials{ServerURL: serverURL, Username: resp.Username, Secret: resp.Secret}, nil
+}
+
+// execHelperList runs `docker-credential-<name> list`, which reports
+// every server URL the helper holds credentials for, mapped to username.
+func execHelperList(name string) (map[string]string, error) {
+	out, err := exec.Command("docker-credential-"+name, "list").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var names map[string]string
+	if err := json.Unmarshal(out, &names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dockercfg
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, contents string) *Resolver {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return &Resolver{configPath: path}
+}
+
+func TestResolverAllDecodesAuths(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("alice:hunter2"))
+	r := writeConfig(t, `{"auths":{"registry.example.com":{"auth":"`+auth+`"}}}`)
+
+	all, err := r.All()
+	if err != nil {
+		t.Fatalf("All(): %v", err)
+	}
+	creds, ok := all["registry.example.com"]
+	if !ok {
+		t.Fatal(`All() has nothing for "registry.example.com"`)
+	}
+	if creds.Username != "alice" || creds.Secret != "hunter2" {
+		t.Errorf("got %+v, want Username=alice Secret=hunter2", creds)
+	}
+}
+
+func TestResolverGetMissingServerIsNotExist(t *testing.T) {
+	r := writeConfig(t, `{}`)
+
+	if _, err := r.Get("missing.example.com"); !os.IsNotExist(err) {
+		t.Errorf("Get() err = %v, want an os.IsNotExist error", err)
+	}
+}
+
+func TestResolverAllMissingFileIsNotExist(t *testing.T) {
+	r := &Resolver{configPath: filepath.Join(t.TempDir(), "does-not-exist.json")}
+
+	if _, err := r.All(); !os.IsNotExist(err) {
+		t.Errorf("All() err = %v, want an os.IsNotExist error", err)
+	}
+}
+
+func TestResolverCredHelpersAndCredsStore(t *testing.T) {
+	bin := t.TempDir()
+	writeFakeHelper(t, bin, "fakehelper")
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	r := writeConfig(t, `{"credHelpers":{"helper.example.com":"fakehelper"},"credsStore":"fakehelper"}`)
+
+	all, err := r.All()
+	if err != nil {
+		t.Fatalf("All(): %v", err)
+	}
+	if creds, ok := all["helper.example.com"]; !ok || creds.Username != "fake-user" {
+		t.Errorf(`All()["helper.example.com"] = %+v, ok=%v, want Username=fake-user, ok=true`, creds, ok)
+	}
+	if creds, ok := all["store.example.com"]; !ok || creds.Username != "fake-user" {
+		t.Errorf(`All()["store.example.com"] = %+v, ok=%v, want Username=fake-user, ok=true`, creds, ok)
+	}
+}
+
+func TestResolverAllSkipsItsOwnHelperName(t *testing.T) {
+	bin := t.TempDir()
+	sentinel := filepath.Join(bin, "invoked")
+	writeSentinelHelper(t, bin, ownHelperName, sentinel)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	r := writeConfig(t, `{"credHelpers":{"gcr.io":"`+ownHelperName+`"},"credsStore":"`+ownHelperName+`"}`)
+
+	all, err := r.All()
+	if err != nil {
+		t.Fatalf("All(): %v", err)
+	}
+	if _, ok := all["gcr.io"]; ok {
+		t.Errorf(`All()["gcr.io"] present, want it skipped since its credHelper is %q`, ownHelperName)
+	}
+	if _, err := os.Stat(sentinel); err == nil {
+		t.Error("All() shelled out to its own helper name instead of skipping it")
+	}
+}
+
+// writeSentinelHelper installs a docker-credential-<name> script that
+// records it ran by creating sentinelPath, so a test can assert it was
+// never invoked.
+func writeSentinelHelper(t *testing.T, dir, name, sentinelPath string) {
+	t.Helper()
+	script := "#!/bin/sh\n" +
+		"touch '" + sentinelPath + "'\n" +
+		"echo '{}'\n"
+
+	path := filepath.Join(dir, "docker-credential-"+name)
+	if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeFakeHelper installs a docker-credential-<name> script implementing
+// just enough of the docker-credential-helpers protocol (get/list) for
+// execHelperGet/execHelperList to exercise against.
+func writeFakeHelper(t *testing.T, dir, name string) {
+	t.Helper()
+	script := "#!/bin/sh\n" +
+		"case \"$1\" in\n" +
+		"get) echo '{\"ServerURL\":\"\",\"Username\":\"fake-user\",\"Secret\":\"fake-secret\"}' ;;\n" +
+		"list) echo '{\"store.example.com\":\"fake-user\"}' ;;\n" +
+		"esac\n"
+
+	path := filepath.Join(dir, "docker-credential-"+name)
+	if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+}
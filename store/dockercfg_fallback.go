@@ -0,0 +1,75 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/google/docker-credential-gcr/store/dockercfg"
+)
+
+// dockercfgFallback lazily resolves credentials from the Docker CLI's own
+// ~/.docker/config.json (and any credsStore/credHelpers it names) for
+// registries not already present in a GCRCredStore's own backing store.
+// Both credStore and helperCredStore embed this, since the fallback
+// applies regardless of which one backs GetOtherCreds/AllThirdPartyCreds.
+type dockercfgFallback struct {
+	resolver *dockercfg.Resolver
+}
+
+// resolverOrNil lazily constructs d.resolver, returning nil if it can't
+// be constructed (e.g. the current user has no home directory).
+func (d *dockercfgFallback) resolverOrNil() *dockercfg.Resolver {
+	if d.resolver == nil {
+		if resolver, err := dockercfg.NewResolver(); err == nil {
+			d.resolver = resolver
+		}
+	}
+	return d.resolver
+}
+
+// mergeOtherCreds adds any credentials resolved from
+// ~/.docker/config.json to own, for registries not already present in it.
+func (d *dockercfgFallback) mergeOtherCreds(own map[string]credentials.Credentials) map[string]credentials.Credentials {
+	resolver := d.resolverOrNil()
+	if resolver == nil {
+		return own
+	}
+
+	fromDockercfg, err := resolver.All()
+	if err != nil {
+		return own
+	}
+	for serverURL, creds := range fromDockercfg {
+		if _, present := own[serverURL]; !present {
+			own[serverURL] = creds
+		}
+	}
+	return own
+}
+
+// getOtherCred resolves serverURL from ~/.docker/config.json, for callers
+// whose own backing store has nothing for it.
+func (d *dockercfgFallback) getOtherCred(serverURL string) (*credentials.Credentials, error) {
+	resolver := d.resolverOrNil()
+	if resolver == nil {
+		return nil, authErr("no credentials present for "+serverURL, nil)
+	}
+
+	creds, err := resolver.Get(serverURL)
+	if err != nil {
+		return nil, authErr("no credentials present for "+serverURL, nil)
+	}
+	return creds, nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// envelopeVersion and envelopeAlg identify the on-disk envelope format
+// produced by aesGCMEncryptor. A version bump would let a future
+// Encryptor recognize and migrate older envelopes.
+const (
+	envelopeVersion = 1
+	envelopeAlg     = "AES-256-GCM"
+)
+
+// envelope is the on-disk encrypted form of a credential file, replacing
+// the plaintext dockerCredentials JSON when encryption is enabled.
+type envelope struct {
+	V     int    `json:"v"`
+	Alg   string `json:"alg"`
+	Nonce string `json:"nonce"`
+	CT    string `json:"ct"`
+}
+
+// isEnvelope reports whether data looks like an encryption envelope
+// rather than legacy plaintext dockerCredentials JSON, so readers can
+// transparently handle either.
+func isEnvelope(data []byte) bool {
+	var probe struct {
+		Alg string `json:"alg"`
+	}
+	return json.Unmarshal(data, &probe) == nil && probe.Alg != ""
+}
+
+// Encryptor encrypts and decrypts the credential file's contents at
+// rest, so refresh tokens aren't kept in plaintext on disk.
+type Encryptor interface {
+	// Encrypt wraps plaintext in an encryption envelope.
+	Encrypt(plaintext []byte) ([]byte, error)
+	// Decrypt unwraps an envelope previously returned by Encrypt.
+	Decrypt(data []byte) ([]byte, error)
+}
+
+// masterKeyring manages the AES-256 master key used to encrypt the
+// credential file, persisting it in OS-provided secure storage and
+// generating a new random one on first use. newMasterKeyring, implemented
+// per-platform, returns nil if no secure storage is available.
+type masterKeyring interface {
+	MasterKey() ([]byte, error)
+}
+
+// masterKeyLockFilename names the lock file guarding concurrent
+// master-key bootstrap, alongside the gcloud config directory.
+const masterKeyLockFilename = "docker_credentials_master.lock"
+
+// withMasterKeyLock runs fn, which should probe OS-provided secure
+// storage for an existing master key and generate+persist one if
+// absent, under an exclusive lock. Without it, two processes racing to
+// bootstrap the key on first use could each see "not found", generate a
+// different random key, and persist their own, leaving whichever one
+// lost the race unable to ever decrypt data encrypted with its
+// (now-orphaned) key again. This mirrors the same class of race
+// withCredentials' lock closes for the credential file itself.
+func withMasterKeyLock(fn func() ([]byte, error)) ([]byte, error) {
+	configPath, err := sdkConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(configPath, 0777); err != nil {
+		return nil, err
+	}
+
+	lock, err := newFileLock(filepath.Join(configPath, masterKeyLockFilename))
+	if err != nil {
+		return nil, authErr("failed to lock master key bootstrap", err)
+	}
+	defer lock.Close()
+
+	return fn()
+}
+
+// newEncryptor returns the Encryptor for this platform, or an error if
+// none is available (e.g. an unsupported OS).
+func newEncryptor() (Encryptor, error) {
+	keyring := newMasterKeyring()
+	if keyring == nil {
+		return nil, errors.New("docker-credential-gcr/store: at-rest encryption is not supported on this platform")
+	}
+	return &aesGCMEncryptor{keyring: keyring}, nil
+}
+
+// aesGCMEncryptor implements Encryptor with AES-256-GCM, deriving its key
+// from OS-provided key material via keyring.
+type aesGCMEncryptor struct {
+	keyring masterKeyring
+}
+
+func (e *aesGCMEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	gcm, err := e.cipher()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	ct := gcm.Seal(nil, nonce, plaintext, nil)
+	return json.Marshal(envelope{
+		V:     envelopeVersion,
+		Alg:   envelopeAlg,
+		Nonce: base64.StdEncoding.EncodeToString(nonce),
+		CT:    base64.StdEncoding.EncodeToString(ct),
+	})
+}
+
+func (e *aesGCMEncryptor) Decrypt(data []byte) ([]byte, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, err
+	}
+	if env.Alg != envelopeAlg {
+		return nil, fmt.Errorf("docker-credential-gcr/store: unsupported encryption envelope alg %q", env.Alg)
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(env.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	ct, err := base64.StdEncoding.DecodeString(env.CT)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := e.cipher()
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, ct, nil)
+}
+
+func (e *aesGCMEncryptor) cipher() (cipher.AEAD, error) {
+	key, err := e.keyring.MasterKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,55 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import "testing"
+
+// fakeMasterKeyring is a fixed, in-memory masterKeyring for tests, since
+// the real per-platform ones need OS-level secure storage.
+type fakeMasterKeyring struct {
+	key []byte
+}
+
+func (f fakeMasterKeyring) MasterKey() ([]byte, error) {
+	return f.key, nil
+}
+
+func TestAESGCMEncryptorRoundTrip(t *testing.T) {
+	e := &aesGCMEncryptor{keyring: fakeMasterKeyring{key: make([]byte, 32)}}
+
+	plaintext := []byte(`{"gcrCreds":{"access_token":"secret"}}`)
+	envelope, err := e.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !isEnvelope(envelope) {
+		t.Fatalf("Encrypt output not recognized as an envelope: %s", envelope)
+	}
+
+	got, err := e.Decrypt(envelope)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if string(got) != string(plaintext) {
+		t.Errorf("Decrypt(Encrypt(p)) = %q, want %q", got, plaintext)
+	}
+}
+
+func TestIsEnvelopeRejectsLegacyPlaintext(t *testing.T) {
+	legacy := []byte(`{"gcrCreds":{"access_token":"secret"},"otherCreds":{}}`)
+	if isEnvelope(legacy) {
+		t.Errorf("isEnvelope(legacy plaintext) = true, want false")
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import "os"
+
+// fileLock is an advisory, cross-process lock backed by a sibling file,
+// guarding the read-modify-write cycles that mutate the credential
+// store. lockFile/unlockFile are implemented per-platform in
+// lock_unix.go and lock_windows.go.
+type fileLock struct {
+	f *os.File
+}
+
+// newFileLock opens (creating if necessary) the file at path and blocks
+// until an exclusive lock on it is acquired.
+func newFileLock(path string) (*fileLock, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileLock{f: f}, nil
+}
+
+// Close releases the lock and closes the underlying file.
+func (l *fileLock) Close() error {
+	err := unlockFile(l.f)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin
+// +build darwin
+
+package store
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"os/exec"
+	"strings"
+)
+
+// masterKeyService is the macOS Keychain "service" attribute the
+// encryption master key is tagged with.
+const masterKeyService = "docker-credential-gcr-master"
+
+// keychainMasterKeyring persists the AES master key as a single generic
+// password in the macOS Keychain.
+type keychainMasterKeyring struct{}
+
+func newMasterKeyring() masterKeyring {
+	return keychainMasterKeyring{}
+}
+
+func (keychainMasterKeyring) MasterKey() ([]byte, error) {
+	return withMasterKeyLock(func() ([]byte, error) {
+		out, err := exec.Command("/usr/bin/security", "find-generic-password",
+			"-s", masterKeyService, "-a", "master", "-w").Output()
+		if err == nil {
+			return base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+		}
+		if !isKeychainItemNotFound(err) {
+			return nil, err
+		}
+
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(key)
+		err = exec.Command("/usr/bin/security", "add-generic-password",
+			"-s", masterKeyService, "-a", "master", "-w", encoded, "-U").Run()
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	})
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package store
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// masterKeyServerURL is the well-known "server URL" the encryption
+// master key is stored under in the Secret Service.
+const masterKeyServerURL = "docker-credential-gcr-master"
+
+// secretServiceMasterKeyring persists the AES master key as a single item
+// in the Linux Secret Service.
+type secretServiceMasterKeyring struct{}
+
+func newMasterKeyring() masterKeyring {
+	return secretServiceMasterKeyring{}
+}
+
+func (secretServiceMasterKeyring) MasterKey() ([]byte, error) {
+	return withMasterKeyLock(func() ([]byte, error) {
+		helper := secretServiceHelper{}
+
+		_, secret, err := helper.Get(masterKeyServerURL)
+		if err == nil {
+			return base64.StdEncoding.DecodeString(secret)
+		}
+		if !credentials.IsErrCredentialsNotFound(err) {
+			return nil, err
+		}
+
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(key)
+		if err := helper.Add(&credentials.Credentials{
+			ServerURL: masterKeyServerURL,
+			Username:  "master",
+			Secret:    encoded,
+		}); err != nil {
+			return nil, err
+		}
+		return key, nil
+	})
+}
@@ -0,0 +1,123 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+// +build windows
+
+package store
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// masterKeyFilename holds the encryption master key, itself encrypted at
+// rest with DPAPI so only the current Windows user account can read it.
+const masterKeyFilename = "docker_credentials_master.key"
+
+var (
+	crypt32                = syscall.NewLazyDLL("crypt32.dll")
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procCryptProtectData   = crypt32.NewProc("CryptProtectData")
+	procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+	procLocalFree          = kernel32.NewProc("LocalFree")
+)
+
+type dataBlob struct {
+	cbData uint32
+	pbData *byte
+}
+
+func newBlob(d []byte) *dataBlob {
+	if len(d) == 0 {
+		return &dataBlob{}
+	}
+	return &dataBlob{cbData: uint32(len(d)), pbData: &d[0]}
+}
+
+func (b *dataBlob) bytes() []byte {
+	return unsafe.Slice(b.pbData, int(b.cbData))
+}
+
+// dpapiMasterKeyring persists the AES master key in a file alongside the
+// credential store, protected at rest via Windows Credential Manager's
+// backing DPAPI (CryptProtectData/CryptUnprotectData).
+type dpapiMasterKeyring struct{}
+
+func newMasterKeyring() masterKeyring {
+	return dpapiMasterKeyring{}
+}
+
+func (dpapiMasterKeyring) MasterKey() ([]byte, error) {
+	return withMasterKeyLock(func() ([]byte, error) {
+		path, err := masterKeyPath()
+		if err != nil {
+			return nil, err
+		}
+
+		if protected, err := os.ReadFile(path); err == nil {
+			return dpapiUnprotect(protected)
+		}
+
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+
+		protected, err := dpapiProtect(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, protected, 0600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	})
+}
+
+func masterKeyPath() (string, error) {
+	configPath, err := sdkConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configPath, masterKeyFilename), nil
+}
+
+func dpapiProtect(data []byte) ([]byte, error) {
+	var out dataBlob
+	r, _, err := procCryptProtectData.Call(
+		uintptr(unsafe.Pointer(newBlob(data))), 0, 0, 0, 0, 0, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, err
+	}
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(out.pbData)))
+	return append([]byte(nil), out.bytes()...), nil
+}
+
+func dpapiUnprotect(data []byte) ([]byte, error) {
+	var out dataBlob
+	r, _, err := procCryptUnprotectData.Call(
+		uintptr(unsafe.Pointer(newBlob(data))), 0, 0, 0, 0, 0, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, err
+	}
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(out.pbData)))
+	return append([]byte(nil), out.bytes()...), nil
+}
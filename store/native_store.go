@@ -0,0 +1,229 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/google/docker-credential-gcr/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// helperCredStore is a GCRCredStore backed by a native OS credential
+// helper (credentials.Helper), such as the macOS Keychain, Windows
+// Credential Manager, or the Linux Secret Service, rather than the
+// JSON file used by credStore.
+type helperCredStore struct {
+	name   string
+	helper credentials.Helper
+
+	// dockercfgFallback resolves credentials from the Docker CLI's own
+	// ~/.docker/config.json when they aren't present in helper.
+	dockercfgFallback
+}
+
+// newHelperCredStore wraps helper as a GCRCredStore, transparently
+// importing any credentials left in the legacy file store on first use.
+func newHelperCredStore(name string, helper credentials.Helper) (GCRCredStore, error) {
+	s := &helperCredStore{name: name, helper: helper}
+	if err := s.migrateFromFileStore(); err != nil {
+		return nil, authErr("failed to migrate credentials into "+name, err)
+	}
+	return s, nil
+}
+
+func (s *helperCredStore) GetOtherCreds(serverURL string) (*credentials.Credentials, error) {
+	username, secret, err := s.helper.Get(serverURL)
+	if err == nil {
+		return &credentials.Credentials{ServerURL: serverURL, Username: username, Secret: secret}, nil
+	}
+	if !credentials.IsErrCredentialsNotFound(err) {
+		return nil, err
+	}
+	return s.getOtherCred(serverURL)
+}
+
+func (s *helperCredStore) SetOtherCreds(newCreds *credentials.Credentials) error {
+	return s.helper.Add(newCreds)
+}
+
+func (s *helperCredStore) DeleteOtherCreds(serverURL string) error {
+	err := s.helper.Delete(serverURL)
+	if credentials.IsErrCredentialsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *helperCredStore) AllThirdPartyCreds() (map[string]credentials.Credentials, error) {
+	listed, err := s.helper.List()
+	if err != nil {
+		return nil, err
+	}
+
+	all := make(map[string]credentials.Credentials, len(listed))
+	for serverURL, username := range listed {
+		if serverURL == backendGCRServerURL || serverURL == backendGCRCredSourceURL {
+			continue
+		}
+		all[serverURL] = credentials.Credentials{Username: username}
+	}
+	return s.mergeOtherCreds(all), nil
+}
+
+// GetGCRAuth creates an GCRAuth for the currently signed-in account.
+func (s *helperCredStore) GetGCRAuth() (*GCRAuth, error) {
+	var cfg *credentialSourceConfig
+	if _, secret, err := s.helper.Get(backendGCRCredSourceURL); err == nil {
+		var c credentialSourceConfig
+		if err := json.Unmarshal([]byte(secret), &c); err == nil {
+			cfg = &c
+		}
+	}
+
+	var t *tokens
+	if _, secret, err := s.helper.Get(backendGCRServerURL); err == nil {
+		var parsed tokens
+		if err := json.Unmarshal([]byte(secret), &parsed); err == nil {
+			t = &parsed
+		}
+	}
+
+	source, err := credentialSourceFromConfig(cfg, t)
+	if err != nil {
+		return nil, err
+	}
+	return &GCRAuth{source: source}, nil
+}
+
+// SetGCRAuth sets the stored GCR credentials.
+func (s *helperCredStore) SetGCRAuth(tok *oauth2.Token) error {
+	secret, err := json.Marshal(tokens{
+		AccessToken:  tok.AccessToken,
+		RefreshToken: tok.RefreshToken,
+		TokenExpiry:  &tok.Expiry,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.helper.Add(&credentials.Credentials{
+		ServerURL: backendGCRServerURL,
+		Username:  "oauth2",
+		Secret:    string(secret),
+	})
+}
+
+// DeleteGCRAuth deletes the stored GCR credentials.
+func (s *helperCredStore) DeleteGCRAuth() error {
+	err := s.helper.Delete(backendGCRServerURL)
+	if credentials.IsErrCredentialsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// SetGCRServiceAccount switches GetGCRAuth to authenticate using the
+// service account key at path, validating it parses as one first.
+func (s *helperCredStore) SetGCRServiceAccount(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return authErr("failed to read service account key "+path, err)
+	}
+	if _, err := google.JWTConfigFromJSON(data, config.GCRScopes...); err != nil {
+		return authErr("not a valid service account key: "+path, err)
+	}
+
+	return s.setCredSource(&credentialSourceConfig{Kind: CredentialSourceServiceAccount, ServiceAccountPath: path})
+}
+
+// SetGCRCredentialSource switches GetGCRAuth to authenticate using the
+// named CredentialSource.
+func (s *helperCredStore) SetGCRCredentialSource(kind string) error {
+	switch kind {
+	case CredentialSourceUser, CredentialSourceGCE, CredentialSourceADC:
+	default:
+		return authErr("unknown GCR credential source "+strconv.Quote(kind), nil)
+	}
+
+	return s.setCredSource(&credentialSourceConfig{Kind: kind})
+}
+
+func (s *helperCredStore) setCredSource(cfg *credentialSourceConfig) error {
+	secret, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	return s.helper.Add(&credentials.Credentials{
+		ServerURL: backendGCRCredSourceURL,
+		Username:  "credsource",
+		Secret:    string(secret),
+	})
+}
+
+// migrateFromFileStore imports any credentials left over in the legacy
+// JSON file store into the native helper, then removes the file so the
+// import only ever happens once.
+func (s *helperCredStore) migrateFromFileStore() error {
+	path, err := dockerCredentialPath()
+	if err != nil {
+		return err
+	}
+
+	legacy := &credStore{credentialPath: path}
+	creds, err := legacy.loadDockerCredentials()
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Nothing to migrate.
+			return nil
+		}
+		return err
+	}
+
+	if creds.GCRCreds != nil {
+		var expiry time.Time
+		if creds.GCRCreds.TokenExpiry != nil {
+			expiry = *creds.GCRCreds.TokenExpiry
+		}
+		if err := s.SetGCRAuth(&oauth2.Token{
+			AccessToken:  creds.GCRCreds.AccessToken,
+			RefreshToken: creds.GCRCreds.RefreshToken,
+			Expiry:       expiry,
+		}); err != nil {
+			return err
+		}
+	}
+
+	for serverURL, c := range creds.OtherCreds {
+		c.ServerURL = serverURL
+		if err := s.SetOtherCreds(&c); err != nil {
+			return err
+		}
+	}
+
+	if creds.CredSource != nil {
+		if err := s.setCredSource(creds.CredSource); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(path)
+}
@@ -26,6 +26,7 @@ import (
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"time"
 
 	"github.com/docker/docker-credential-helpers/credentials"
@@ -46,12 +47,13 @@ type tokens struct {
 type dockerCredentials struct {
 	GCRCreds   *tokens                            `json:"gcrCreds,omitempty"`
 	OtherCreds map[string]credentials.Credentials `json:"otherCreds,omitempty"`
+	CredSource *credentialSourceConfig            `json:"credSource,omitempty"`
 }
 
-// A GCRAuth provides access to tokens from a prior login.
+// A GCRAuth provides access to tokens from a prior login, a service
+// account, or any other CredentialSource.
 type GCRAuth struct {
-	conf         *oauth2.Config
-	initialToken *oauth2.Token
+	source CredentialSource
 }
 
 // TokenSource returns an oauth2.TokenSource that retrieve tokens from
@@ -59,8 +61,10 @@ type GCRAuth struct {
 // It will returns the current access token stored in the credentials,
 // and refresh it when it expires, but it won't update the credentials
 // with the new access token.
+// For a service account, GCE or ADC CredentialSource, tokens come from
+// that source instead of the stored credentials.
 func (a *GCRAuth) TokenSource(ctx context.Context) oauth2.TokenSource {
-	return a.conf.TokenSource(ctx, a.initialToken)
+	return a.source.TokenSource(ctx)
 }
 
 // GCRCredStore describes the interface for a store capable of storing both
@@ -71,6 +75,15 @@ type GCRCredStore interface {
 	SetGCRAuth(tok *oauth2.Token) error
 	DeleteGCRAuth() error
 
+	// SetGCRServiceAccount switches GetGCRAuth to authenticate with the
+	// service account key at path, instead of a stored user login.
+	SetGCRServiceAccount(path string) error
+	// SetGCRCredentialSource switches GetGCRAuth to authenticate using
+	// the named CredentialSource (one of the CredentialSource* consts
+	// other than CredentialSourceServiceAccount, which requires a key
+	// path and so goes through SetGCRServiceAccount instead).
+	SetGCRCredentialSource(kind string) error
+
 	GetOtherCreds(string) (*credentials.Credentials, error)
 	SetOtherCreds(*credentials.Credentials) error
 	DeleteOtherCreds(string) error
@@ -79,16 +92,73 @@ type GCRCredStore interface {
 
 type credStore struct {
 	credentialPath string
+
+	// dockercfgFallback resolves credentials from the Docker CLI's own
+	// ~/.docker/config.json when they aren't present in credentialPath.
+	dockercfgFallback
+
+	// encryptor, if set, encrypts credentialPath at rest. A nil
+	// encryptor keeps the legacy plaintext format.
+	encryptor Encryptor
 }
 
-// NewGCRCredStore returns a GCRCredStore which is backed by a file.
+// encryptCredentialFileEnvVar opts the plaintext file store into at-rest
+// encryption (see NewEncryptedGCRCredStore) without requiring callers to
+// change which constructor they call.
+const encryptCredentialFileEnvVar = "DOCKER_CREDENTIAL_GCR_ENCRYPT"
+
+// NewGCRCredStore returns a GCRCredStore backed by the best available
+// secret store: a native OS credential helper (the macOS Keychain,
+// Windows Credential Manager, or the Linux Secret Service) when one is
+// configured or detected for this platform, falling back to a local JSON
+// file otherwise. The store to use can be overridden via the
+// DOCKER_CREDENTIAL_GCR_STORE environment variable or the
+// docker_credential_helper.conf file; see selectedHelper. Setting
+// DOCKER_CREDENTIAL_GCR_ENCRYPT to a value strconv.ParseBool reads as
+// true encrypts the file fallback at rest, as if NewEncryptedGCRCredStore
+// had been called instead.
 func NewGCRCredStore() (GCRCredStore, error) {
+	name, helper, ok, err := selectedHelper()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return newHelperCredStore(name, helper)
+	}
+
+	if truthyEnv(os.Getenv(encryptCredentialFileEnvVar)) {
+		return NewEncryptedGCRCredStore()
+	}
+
 	path, err := dockerCredentialPath()
 	return &credStore{
 		credentialPath: path,
 	}, err
 }
 
+// NewEncryptedGCRCredStore returns a GCRCredStore like the file fallback
+// of NewGCRCredStore, but with docker_credentials.json encrypted at rest
+// using a key derived from OS-provided key material (see newEncryptor).
+// It never selects a native OS credential helper, even if one is
+// configured, since those already keep secrets out of plaintext files.
+func NewEncryptedGCRCredStore() (GCRCredStore, error) {
+	encryptor, err := newEncryptor()
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := dockerCredentialPath()
+	if err != nil {
+		return nil, err
+	}
+	return &credStore{credentialPath: path, encryptor: encryptor}, nil
+}
+
+func truthyEnv(v string) bool {
+	b, _ := strconv.ParseBool(v)
+	return b
+}
+
 // GetOtherCreds returns the stored credentials corresponding to the given
 // registry URL, or an error if the credentials cannot be retrieved or do not
 // exist.
@@ -98,12 +168,11 @@ func (s *credStore) GetOtherCreds(serverURL string) (*credentials.Credentials, e
 		return nil, err
 	}
 
-	creds, present := all3pCreds[serverURL]
-	if !present {
-		return nil, authErr("no credentials present for "+serverURL, nil)
+	if creds, present := all3pCreds[serverURL]; present {
+		return &creds, nil
 	}
 
-	return &creds, nil
+	return nil, authErr("no credentials present for "+serverURL, nil)
 }
 
 // SetOtherCreds stores the given credentials under the repository URL
@@ -111,54 +180,49 @@ func (s *credStore) GetOtherCreds(serverURL string) (*credentials.Credentials, e
 func (s *credStore) SetOtherCreds(newCreds *credentials.Credentials) error {
 	serverURL := newCreds.ServerURL
 	newCreds.ServerURL = "" // wasted space
-	creds, err := s.loadDockerCredentials()
-	if err != nil {
-		// It's OK if we couldn't read any credentials,
-		// making a new file.
-		creds = &dockerCredentials{}
-	}
-	if creds.OtherCreds == nil {
-		creds.OtherCreds = map[string]credentials.Credentials{}
-	}
-
-	creds.OtherCreds[serverURL] = *newCreds
 
-	return s.setDockerCredentials(creds)
+	return s.withCredentials(func(creds *dockerCredentials) (*dockerCredentials, error) {
+		if creds.OtherCreds == nil {
+			creds.OtherCreds = map[string]credentials.Credentials{}
+		}
+		creds.OtherCreds[serverURL] = *newCreds
+		return creds, nil
+	})
 }
 
 // DeleteOtherCreds removes the Docker credentials corresponding to the
 // given serverURL, returning an error if the credentials existed but could
 // not be erased.
 func (s *credStore) DeleteOtherCreds(serverURL string) error {
-	creds, err := s.loadDockerCredentials()
-	if err != nil {
-		if os.IsNotExist(err) {
-			// No file, no credentials.
-			return nil
+	return s.withCredentials(func(creds *dockerCredentials) (*dockerCredentials, error) {
+		// Optimization: only perform a write if a change must be made.
+		if _, exists := creds.OtherCreds[serverURL]; !exists {
+			return nil, nil
 		}
-		return err
-	}
-
-	// Optimization: only perform a 'set' if a change must be made
-	if creds.OtherCreds != nil {
-		if _, exists := creds.OtherCreds[serverURL]; exists {
-			delete(creds.OtherCreds, serverURL)
-			return s.setDockerCredentials(creds)
-		}
-	}
-
-	return nil
+		delete(creds.OtherCreds, serverURL)
+		return creds, nil
+	})
 }
 
 // AllThirdPartyCreds returns a map of all 3rd party repositories to their
-// associated Docker credentials.Credentials.
+// associated Docker credentials.Credentials, merging in anything resolved
+// from the Docker CLI's own config (see dockercfgFallback) that isn't
+// already present in our own store.
 func (s *credStore) AllThirdPartyCreds() (map[string]credentials.Credentials, error) {
 	allCreds, err := s.loadDockerCredentials()
 	if err != nil {
-		return nil, err
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		allCreds = &dockerCredentials{}
 	}
 
-	return allCreds.OtherCreds, nil
+	merged := map[string]credentials.Credentials{}
+	for serverURL, creds := range allCreds.OtherCreds {
+		merged[serverURL] = creds
+	}
+
+	return s.mergeOtherCreds(merged), nil
 }
 
 // GetGCRAuth creates an GCRAuth for the currently signed-in account.
@@ -168,108 +232,165 @@ func (s *credStore) GetGCRAuth() (*GCRAuth, error) {
 		return nil, err
 	}
 
-	if creds.GCRCreds == nil {
-		return nil, errors.New("GCR Credentials not present in store")
-	}
-
-	var expiry time.Time
-	if creds.GCRCreds.TokenExpiry != nil {
-		expiry = *creds.GCRCreds.TokenExpiry
+	source, err := credentialSourceFromConfig(creds.CredSource, creds.GCRCreds)
+	if err != nil {
+		return nil, err
 	}
-
-	return &GCRAuth{
-		conf: &oauth2.Config{
-			ClientID:     config.GCRCredHelperClientID,
-			ClientSecret: config.GCRCredHelperClientNotSoSecret,
-			Scopes:       config.GCRScopes,
-			Endpoint:     google.Endpoint,
-			RedirectURL:  "oob",
-		},
-		initialToken: &oauth2.Token{
-			AccessToken:  creds.GCRCreds.AccessToken,
-			RefreshToken: creds.GCRCreds.RefreshToken,
-			Expiry:       expiry,
-		},
-	}, nil
+	return &GCRAuth{source: source}, nil
 }
 
 // SetGCRAuth sets the stored GCR credentials.
 func (s *credStore) SetGCRAuth(tok *oauth2.Token) error {
-	creds, err := s.loadDockerCredentials()
+	return s.withCredentials(func(creds *dockerCredentials) (*dockerCredentials, error) {
+		creds.GCRCreds = &tokens{
+			AccessToken:  tok.AccessToken,
+			RefreshToken: tok.RefreshToken,
+			TokenExpiry:  &tok.Expiry,
+		}
+		return creds, nil
+	})
+}
+
+// SetGCRServiceAccount switches GetGCRAuth to authenticate using the
+// service account key at path, validating it parses as one first.
+func (s *credStore) SetGCRServiceAccount(path string) error {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		// It's OK if we couldn't read any credentials,
-		// making a new file.
-		creds = &dockerCredentials{}
+		return authErr("failed to read service account key "+path, err)
+	}
+	if _, err := google.JWTConfigFromJSON(data, config.GCRScopes...); err != nil {
+		return authErr("not a valid service account key: "+path, err)
 	}
 
-	creds.GCRCreds = &tokens{
-		AccessToken:  tok.AccessToken,
-		RefreshToken: tok.RefreshToken,
-		TokenExpiry:  &tok.Expiry,
+	return s.withCredentials(func(creds *dockerCredentials) (*dockerCredentials, error) {
+		creds.CredSource = &credentialSourceConfig{Kind: CredentialSourceServiceAccount, ServiceAccountPath: path}
+		return creds, nil
+	})
+}
+
+// SetGCRCredentialSource switches GetGCRAuth to authenticate using the
+// named CredentialSource.
+func (s *credStore) SetGCRCredentialSource(kind string) error {
+	switch kind {
+	case CredentialSourceUser, CredentialSourceGCE, CredentialSourceADC:
+	default:
+		return authErr("unknown GCR credential source "+strconv.Quote(kind), nil)
 	}
 
-	return s.setDockerCredentials(creds)
+	return s.withCredentials(func(creds *dockerCredentials) (*dockerCredentials, error) {
+		creds.CredSource = &credentialSourceConfig{Kind: kind}
+		return creds, nil
+	})
 }
 
 // DeleteGCRAuth deletes the stored GCR credentials.
 func (s *credStore) DeleteGCRAuth() error {
-	creds, err := s.loadDockerCredentials()
-	if err != nil {
-		if os.IsNotExist(err) {
-			// No file, no credentials.
-			return nil
+	return s.withCredentials(func(creds *dockerCredentials) (*dockerCredentials, error) {
+		// Optimization: only perform a write if necessary.
+		if creds.GCRCreds == nil {
+			return nil, nil
 		}
+		creds.GCRCreds = nil
+		return creds, nil
+	})
+}
+
+// withCredentials runs fn under an exclusive lock on a sibling .lock
+// file, re-reading the current on-disk credentials inside that lock so
+// concurrent callers can't clobber each other's writes, then atomically
+// persists whatever fn returns. fn may return (nil, nil) to indicate no
+// write is necessary.
+func (s *credStore) withCredentials(fn func(*dockerCredentials) (*dockerCredentials, error)) error {
+	if err := os.MkdirAll(filepath.Dir(s.credentialPath), 0777); err != nil {
 		return err
 	}
 
-	// Optimization: only perform a 'set' if necessary
-	if creds.GCRCreds != nil {
-		creds.GCRCreds = nil
-		return s.setDockerCredentials(creds)
+	lock, err := newFileLock(s.credentialPath + ".lock")
+	if err != nil {
+		return authErr("failed to lock credential store", err)
 	}
-	return nil
-}
+	defer lock.Close()
 
-func (s *credStore) createCredentialFile() (*os.File, error) {
-	// create the gcloud config dir, if it doesnt exist
-	if err := os.MkdirAll(filepath.Dir(s.credentialPath), 0777); err != nil {
-		return nil, err
+	creds, err := s.loadDockerCredentials()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		creds = &dockerCredentials{}
 	}
-	// next, create the credential file, or truncate (clear) it if it exists
-	f, err := os.Create(s.credentialPath)
+
+	updated, err := fn(creds)
 	if err != nil {
-		return nil, authErr("failed to create credential file", err)
+		return err
+	}
+	if updated == nil {
+		return nil
 	}
-	return f, nil
+
+	return s.setDockerCredentials(updated)
 }
 
 func (s *credStore) loadDockerCredentials() (*dockerCredentials, error) {
 	path := s.credentialPath
-	f, err := os.Open(path)
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+
+	if isEnvelope(data) {
+		if s.encryptor == nil {
+			return nil, authErr("credential file "+path+" is encrypted but no encryptor is configured", nil)
+		}
+		if data, err = s.encryptor.Decrypt(data); err != nil {
+			return nil, authErr("failed to decrypt credentials from "+path, err)
+		}
+	}
 
 	var creds dockerCredentials
-	if err := json.NewDecoder(f).Decode(&creds); err != nil {
+	if err := json.Unmarshal(data, &creds); err != nil {
 		return nil, authErr("failed to decode credentials from "+path, err)
 	}
 
 	return &creds, nil
 }
 
+// setDockerCredentials writes creds to a temporary file alongside
+// credentialPath and renames it into place, so readers never observe a
+// partially-written file and a crash mid-write can't corrupt it. If an
+// encryptor is configured, the plaintext is wrapped in an encryption
+// envelope first; this is how a legacy plaintext file transparently gets
+// upgraded to the encrypted format on its next write.
 func (s *credStore) setDockerCredentials(creds *dockerCredentials) error {
-	f, err := s.createCredentialFile()
+	data, err := json.Marshal(creds)
 	if err != nil {
 		return err
 	}
 
-	err = json.NewEncoder(f).Encode(creds)
-	if cerr := f.Close(); err == nil {
-		return cerr
+	if s.encryptor != nil {
+		if data, err = s.encryptor.Encrypt(data); err != nil {
+			return authErr("failed to encrypt credentials", err)
+		}
+	}
+
+	tmpPath := s.credentialPath + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return authErr("failed to create credential file", err)
+	}
+
+	_, writeErr := f.Write(data)
+	if cerr := f.Close(); writeErr == nil {
+		writeErr = cerr
 	}
-	return err
+	if writeErr != nil {
+		os.Remove(tmpPath)
+		return writeErr
+	}
+
+	if err := os.Rename(tmpPath, s.credentialPath); err != nil {
+		return authErr("failed to replace credential file", err)
+	}
+	return nil
 }
 
 // dockerCredentialPath returns the full path of our Docker credential store.
@@ -306,4 +427,4 @@ func authErr(message string, err error) error {
 		return fmt.Errorf("docker-credential-gcr/store: %s", message)
 	}
 	return fmt.Errorf("docker-credential-gcr/store: %s: %v", message, err)
-}
\ No newline at end of file
+}
@@ -0,0 +1,61 @@
+// Copyright 2016 Google, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+func TestSetOtherCredsConcurrent(t *testing.T) {
+	s := &credStore{credentialPath: filepath.Join(t.TempDir(), credentialStoreFilename)}
+
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			serverURL := fmt.Sprintf("registry-%d.example.com", i)
+			err := s.SetOtherCreds(&credentials.Credentials{
+				ServerURL: serverURL,
+				Username:  "user",
+				Secret:    "secret",
+			})
+			if err != nil {
+				t.Errorf("SetOtherCreds(%s): %v", serverURL, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	all, err := s.AllThirdPartyCreds()
+	if err != nil {
+		t.Fatalf("AllThirdPartyCreds: %v", err)
+	}
+	if len(all) < n {
+		t.Fatalf("got %d stored credentials, want at least %d", len(all), n)
+	}
+	for i := 0; i < n; i++ {
+		serverURL := fmt.Sprintf("registry-%d.example.com", i)
+		if _, ok := all[serverURL]; !ok {
+			t.Errorf("missing credentials for %s", serverURL)
+		}
+	}
+}